@@ -1,17 +1,25 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"time"
 
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
 	"GoRateLimiter/pkg/adaptive"
 	"GoRateLimiter/pkg/health" // Ensure this is the correct module path
+	"GoRateLimiter/pkg/metrics"
 )
 
-// Global Limiter Instance
-var adaptiveLimiter *adaptive.AdaptiveLimiter
+// Global Limiter Instance, keyed per-client so one noisy caller can't eat
+// the whole service's budget.
+var perKeyLimiter *adaptive.PerKeyLimiter
 
 // NOTE: Replace this with the actual URL of your Prometheus server
 const PROMETHEUS_URL = "http://localhost:9090"
@@ -22,7 +30,14 @@ func main() {
 	const MonitorInterval = 5 * time.Second
 
 	log.Println("Initializing Adaptive Rate Limiter...")
-	adaptiveLimiter = adaptive.NewAdaptiveLimiter(BaseRPS)
+	perKeyLimiter = adaptive.NewPerKeyLimiter(BaseRPS)
+
+	// Self-observability: a dedicated registry so these metrics can be
+	// embedded alongside an existing app's own, instead of claiming the
+	// global default registry.
+	metricsReg := prometheus.NewRegistry()
+	rateLimiterMetrics := metrics.Register(metricsReg)
+	perKeyLimiter.Metrics = rateLimiterMetrics
 
 	// --- 2. START THE ADAPTIVE MONITOR (Using REAL Prometheus Data) ---
 
@@ -33,39 +48,65 @@ func main() {
 	}
 
 	// ⚠️ 2. Start the Monitor with the REAL source
-	monitor := adaptive.NewMonitor(adaptiveLimiter, realSource, MonitorInterval)
+	monitor := adaptive.NewMonitor(perKeyLimiter, realSource, MonitorInterval)
+	monitor.Metrics = rateLimiterMetrics
 
 	go monitor.StartMonitoring()
 	log.Printf("Adaptive Monitor started, fetching metrics from: %s", PROMETHEUS_URL)
 
+	// --- 2b. OPT-IN: CLUSTER-WIDE COORDINATION ---
+	// If REDIS_ADDR is set, treat BaseRPS as a budget for the whole fleet
+	// instead of per-process: each instance heartbeats into Redis and
+	// divides BaseRPS by the number of live instances it sees. This
+	// replaces per-client shaping with one shared, cluster-wide bucket.
+	// 0 tells the middleware to fall back to DefaultMaxWait (1/(2*rate))
+	// per source, instead of a fixed shaping bound for this route.
+	var dataEndpoint http.Handler = adaptive.NewPerKeyReservationMiddleware(perKeyLimiter, adaptive.DefaultSourceExtractor, 0)(http.HandlerFunc(dataHandler))
+	if redisAddr := os.Getenv("REDIS_ADDR"); redisAddr != "" {
+		rdb := redis.NewClient(&redis.Options{Addr: redisAddr})
+		globalLimiter := adaptive.NewGlobalAdaptiveLimiter(rdb, "gorate", BaseRPS)
+		globalLimiter.Metrics = rateLimiterMetrics
+		globalLimiter.Start(context.Background())
+		monitor.Limiter = globalLimiter
+		dataEndpoint = globalRateLimitMiddleware(globalLimiter, http.HandlerFunc(dataHandler))
+		log.Printf("Cluster-wide adaptive limiting enabled via Redis at %s", redisAddr)
+	}
+
 	// --- 3. START THE SERVER ---
-	http.Handle("/api/data", rateLimitMiddleware(http.HandlerFunc(dataHandler)))
+	http.Handle("/api/data", dataEndpoint)
 	http.Handle("/status", http.HandlerFunc(statusHandler))
+	http.Handle("/metrics", promhttp.HandlerFor(metricsReg, promhttp.HandlerOpts{}))
 
 	fmt.Println("Server starting on :8080. The rate limit is now dynamically adjusting based on real Prometheus metrics.")
 	log.Fatal(http.ListenAndServe(":8080", nil))
 }
 
-// ... rest of rateLimitMiddleware and handler functions ...
-// / rateLimitMiddleware is the critical function that wraps our core handlers.
-func rateLimitMiddleware(next http.Handler) http.Handler {
+// globalRateLimitMiddleware gates next behind a cluster-wide limiter shared
+// by every instance, rather than per-client shaping, shaping traffic with
+// AllowOrReserve the same way the per-key reservation middleware does so a
+// fleet-wide budget still gets a correct Retry-After instead of a hard 429.
+func globalRateLimitMiddleware(limiter *adaptive.GlobalAdaptiveLimiter, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// 1. Get the identifier (e.g., IP address)
-		// ⚠️ FIX: Commented out for now to resolve 'declared and not used' error.
-		// TODO: Re-enable and use 'identifier' once adaptiveLimiter is updated for per-client limits.
-		// identifier := r.RemoteAddr
-
-		// 2. Execute the global adaptive rate limiter check
-		if adaptiveLimiter.Allow() {
-			// Request is ALLOWED: Pass control to the next handler (dataHandler)
-			next.ServeHTTP(w, r)
+		ok, delay := limiter.AllowOrReserve(limiter.DefaultMaxWait())
+		if !ok {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Retry-After", fmt.Sprintf("%.0f", delay.Seconds()))
+			w.WriteHeader(http.StatusTooManyRequests)
+			fmt.Fprintf(w, `{"error": "Rate limit exceeded. Try again later."}`)
 			return
 		}
 
-		// 3. Request is DENIED: Respond with HTTP 429
-		w.WriteHeader(http.StatusTooManyRequests) // 429 Too Many Requests
-		w.Header().Set("Content-Type", "application/json")
-		fmt.Fprintf(w, `{"error": "Rate limit exceeded. Try again later."}`)
+		if delay > 0 {
+			timer := time.NewTimer(delay)
+			defer timer.Stop()
+			select {
+			case <-timer.C:
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
 	})
 }
 
@@ -0,0 +1,259 @@
+package adaptive
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"golang.org/x/time/rate"
+
+	"GoRateLimiter/pkg/metrics"
+)
+
+const (
+	defaultHeartbeatTTL      = 15 * time.Second
+	defaultHeartbeatInterval = 5 * time.Second
+	defaultCountInterval     = 5 * time.Second
+)
+
+// GlobalAdaptiveLimiter turns BaseRPS into a cluster-wide target instead of
+// a per-process one. Each instance publishes a heartbeat to Redis at
+// "instances:<service>:<instance-id>"; a background routine periodically
+// counts the live heartbeats (N) and the local share becomes
+// (BaseRPS * factor) / N, recomputed whenever N changes or UpdateFactor is
+// called by the Monitor.
+//
+// This is deliberately one shared fleet-wide budget, not a per-source one:
+// unlike PerKeyLimiter, GlobalAdaptiveLimiter has no notion of a calling
+// client, so switching to cluster-wide mode trades away per-key isolation
+// (a single noisy caller can again consume the whole budget) in exchange for
+// the limit actually meaning something across the fleet instead of per
+// process. Pick the mode that matches what you're protecting against.
+type GlobalAdaptiveLimiter struct {
+	mu sync.RWMutex
+
+	rdb         *redis.Client
+	serviceName string
+	instanceID  string
+
+	baseRPS float64
+	factor  float64
+	lastN   int64
+
+	heartbeatTTL      time.Duration
+	heartbeatInterval time.Duration
+	countInterval     time.Duration
+
+	underlying *rate.Limiter
+
+	stop chan struct{}
+
+	// Metrics is optional; when set, Allow/AllowOrReserve/UpdateFactor
+	// record outcomes against it.
+	Metrics *metrics.Metrics
+}
+
+// NewGlobalAdaptiveLimiter creates a GlobalAdaptiveLimiter that coordinates
+// baseRPS across every process sharing rdb and serviceName. Call Start to
+// begin publishing heartbeats and recomputing the local share; until then
+// it behaves like a plain per-process limiter at baseRPS.
+func NewGlobalAdaptiveLimiter(rdb *redis.Client, serviceName string, baseRPS float64) *GlobalAdaptiveLimiter {
+	instanceID := fmt.Sprintf("%d-%d", time.Now().UnixNano(), rand.Intn(1_000_000))
+
+	return &GlobalAdaptiveLimiter{
+		rdb:               rdb,
+		serviceName:       serviceName,
+		instanceID:        instanceID,
+		baseRPS:           baseRPS,
+		factor:            1.0,
+		lastN:             1,
+		heartbeatTTL:      defaultHeartbeatTTL,
+		heartbeatInterval: defaultHeartbeatInterval,
+		countInterval:     defaultCountInterval,
+		underlying:        rate.NewLimiter(rate.Limit(baseRPS), int(baseRPS)),
+		stop:              make(chan struct{}),
+	}
+}
+
+// instanceKey is the heartbeat key this process publishes.
+func (l *GlobalAdaptiveLimiter) instanceKey() string {
+	return fmt.Sprintf("instances:%s:%s", l.serviceName, l.instanceID)
+}
+
+// Start launches the heartbeat and instance-count background routines. It
+// should be called once, typically right after construction.
+func (l *GlobalAdaptiveLimiter) Start(ctx context.Context) {
+	go l.heartbeatLoop(ctx)
+	go l.countLoop(ctx)
+}
+
+// Stop halts the background routines.
+func (l *GlobalAdaptiveLimiter) Stop() {
+	close(l.stop)
+}
+
+func (l *GlobalAdaptiveLimiter) heartbeatLoop(ctx context.Context) {
+	l.publishHeartbeat(ctx)
+
+	ticker := time.NewTicker(l.heartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			// Jitter the refresh so a fleet that restarted together
+			// doesn't hammer Redis with synchronized heartbeats.
+			time.Sleep(time.Duration(rand.Int63n(int64(l.heartbeatInterval) / 4)))
+			l.publishHeartbeat(ctx)
+		case <-l.stop:
+			return
+		}
+	}
+}
+
+func (l *GlobalAdaptiveLimiter) publishHeartbeat(ctx context.Context) {
+	if err := l.rdb.Set(ctx, l.instanceKey(), 1, l.heartbeatTTL).Err(); err != nil {
+		log.Printf("[GlobalAdaptiveLimiter] heartbeat failed, degrading to last known instance count: %v", err)
+	}
+}
+
+func (l *GlobalAdaptiveLimiter) countLoop(ctx context.Context) {
+	l.refreshInstanceCount(ctx)
+
+	ticker := time.NewTicker(l.countInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			l.refreshInstanceCount(ctx)
+		case <-l.stop:
+			return
+		}
+	}
+}
+
+// refreshInstanceCount counts the live heartbeat keys and, on success,
+// applies the new N. If Redis is unreachable it leaves lastN untouched so
+// the limiter degrades gracefully to BaseRPS / lastKnownN.
+func (l *GlobalAdaptiveLimiter) refreshInstanceCount(ctx context.Context) {
+	pattern := fmt.Sprintf("instances:%s:*", l.serviceName)
+
+	var n int64
+	iter := l.rdb.Scan(ctx, 0, pattern, 0).Iterator()
+	for iter.Next(ctx) {
+		n++
+	}
+	if err := iter.Err(); err != nil {
+		l.mu.RLock()
+		lastN := l.lastN
+		l.mu.RUnlock()
+		log.Printf("[GlobalAdaptiveLimiter] could not count live instances, falling back to last known N=%d: %v", lastN, err)
+		return
+	}
+	if n == 0 {
+		n = 1
+	}
+
+	l.mu.Lock()
+	changed := n != l.lastN
+	l.lastN = n
+	l.mu.Unlock()
+
+	if changed {
+		l.applyLimit()
+	}
+}
+
+// UpdateFactor is called by the Monitor whenever the adaptive factor
+// changes; the effective local limit is recomputed immediately.
+func (l *GlobalAdaptiveLimiter) UpdateFactor(factor float64) {
+	l.mu.Lock()
+	l.factor = factor
+	l.mu.Unlock()
+
+	l.applyLimit()
+}
+
+// applyLimit recomputes (BaseRPS * factor) / N and pushes it to the
+// underlying per-process limiter.
+func (l *GlobalAdaptiveLimiter) applyLimit() {
+	l.mu.RLock()
+	factor := l.factor
+	localRPS := l.baseRPS * factor / float64(l.lastN)
+	l.mu.RUnlock()
+
+	if localRPS <= 0 {
+		localRPS = 0.01 // never fully stall; keep admitting occasional traffic
+	}
+
+	burst := int(localRPS)
+	if burst < 1 {
+		burst = 1
+	}
+
+	l.underlying.SetLimit(rate.Limit(localRPS))
+	l.underlying.SetBurst(burst)
+
+	if l.Metrics != nil {
+		l.Metrics.AdaptiveFactor.Set(factor)
+		l.Metrics.EffectiveRPS.Set(localRPS)
+	}
+}
+
+// Allow reports whether a request may proceed right now, consuming a token
+// if so; unlike AllowOrReserve it never waits for one.
+func (l *GlobalAdaptiveLimiter) Allow() bool {
+	allowed := l.underlying.Allow()
+
+	if l.Metrics != nil {
+		if allowed {
+			l.Metrics.RequestsAllowed.WithLabelValues("global", "global_adaptive").Inc()
+		} else {
+			l.Metrics.RequestsDenied.WithLabelValues("global", "global_adaptive", "adaptive").Inc()
+		}
+	}
+	return allowed
+}
+
+// AllowOrReserve shapes traffic instead of hard-dropping it: it reserves the
+// next available token and reports how long the caller must wait before
+// using it. If that wait exceeds maxWait, the reservation is cancelled (so
+// it doesn't consume future capacity) and ok is false, with delay set to the
+// wait the caller could have expected — callers typically surface this as a
+// Retry-After header.
+func (l *GlobalAdaptiveLimiter) AllowOrReserve(maxWait time.Duration) (ok bool, delay time.Duration) {
+	r := l.underlying.ReserveN(time.Now(), 1)
+	if !r.OK() {
+		return false, 0
+	}
+
+	delay = r.Delay()
+	if delay > maxWait {
+		r.Cancel()
+		if l.Metrics != nil {
+			l.Metrics.RequestsDenied.WithLabelValues("global", "global_adaptive", "adaptive").Inc()
+		}
+		return false, delay
+	}
+
+	if l.Metrics != nil {
+		l.Metrics.RequestsAllowed.WithLabelValues("global", "global_adaptive").Inc()
+		l.Metrics.WaitSeconds.Observe(delay.Seconds())
+	}
+	return true, delay
+}
+
+// DefaultMaxWait returns a sane shaping bound of half a token's worth of
+// wait time (1 / (2 * rate)) for routes that don't configure their own.
+func (l *GlobalAdaptiveLimiter) DefaultMaxWait() time.Duration {
+	limit := float64(l.underlying.Limit())
+	if limit <= 0 {
+		return 0
+	}
+	return time.Duration(float64(time.Second) / (2 * limit))
+}
@@ -0,0 +1,123 @@
+package adaptive
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+func newTestGlobalLimiter(t *testing.T, baseRPS float64) (*GlobalAdaptiveLimiter, *miniredis.Miniredis) {
+	t.Helper()
+
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("could not start miniredis: %v", err)
+	}
+	t.Cleanup(mr.Close)
+
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	return NewGlobalAdaptiveLimiter(rdb, "svc", baseRPS), mr
+}
+
+// TestGlobalAdaptiveLimiter_ApplyLimit_Math drives applyLimit's
+// (baseRPS*factor)/N computation directly, including the floor that keeps
+// burst from dropping to zero.
+func TestGlobalAdaptiveLimiter_ApplyLimit_Math(t *testing.T) {
+	cases := []struct {
+		name      string
+		baseRPS   float64
+		factor    float64
+		n         int64
+		wantLimit float64
+		wantBurst int
+	}{
+		{name: "single instance, no throttling", baseRPS: 100, factor: 1.0, n: 1, wantLimit: 100, wantBurst: 100},
+		{name: "throttled factor", baseRPS: 100, factor: 0.5, n: 1, wantLimit: 50, wantBurst: 50},
+		{name: "split across a fleet", baseRPS: 100, factor: 1.0, n: 4, wantLimit: 25, wantBurst: 25},
+		{name: "fleet larger than capacity floors burst at 1", baseRPS: 10, factor: 1.0, n: 50, wantLimit: 0.2, wantBurst: 1},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			l, _ := newTestGlobalLimiter(t, tc.baseRPS)
+
+			l.mu.Lock()
+			l.factor = tc.factor
+			l.lastN = tc.n
+			l.mu.Unlock()
+
+			l.applyLimit()
+
+			if got := float64(l.underlying.Limit()); got != tc.wantLimit {
+				t.Fatalf("expected limit %f, got %f", tc.wantLimit, got)
+			}
+			if got := l.underlying.Burst(); got != tc.wantBurst {
+				t.Fatalf("expected burst %d, got %d", tc.wantBurst, got)
+			}
+		})
+	}
+}
+
+// TestGlobalAdaptiveLimiter_RefreshInstanceCount_NoHeartbeatsDegradesToOne
+// covers the N==0 case: with no live heartbeat keys in Redis, refreshing
+// should still leave the limiter usable by treating the fleet as size 1
+// rather than dividing by zero.
+func TestGlobalAdaptiveLimiter_RefreshInstanceCount_NoHeartbeatsDegradesToOne(t *testing.T) {
+	l, _ := newTestGlobalLimiter(t, 100)
+
+	l.refreshInstanceCount(context.Background())
+
+	l.mu.RLock()
+	n := l.lastN
+	l.mu.RUnlock()
+	if n != 1 {
+		t.Fatalf("expected N to degrade to 1 with no live heartbeats, got %d", n)
+	}
+}
+
+// TestGlobalAdaptiveLimiter_RefreshInstanceCount_CountsLiveHeartbeats seeds
+// heartbeat keys the way publishHeartbeat would and checks they're counted.
+func TestGlobalAdaptiveLimiter_RefreshInstanceCount_CountsLiveHeartbeats(t *testing.T) {
+	l, mr := newTestGlobalLimiter(t, 100)
+
+	for i := 0; i < 3; i++ {
+		if err := mr.Set(fmt.Sprintf("instances:svc:instance-%d", i), "1"); err != nil {
+			t.Fatalf("seeding heartbeat: %v", err)
+		}
+	}
+
+	l.refreshInstanceCount(context.Background())
+
+	l.mu.RLock()
+	n := l.lastN
+	l.mu.RUnlock()
+	if n != 3 {
+		t.Fatalf("expected N to count the 3 live heartbeats, got %d", n)
+	}
+}
+
+// TestGlobalAdaptiveLimiter_RefreshInstanceCount_RedisUnreachableKeepsLastKnownN
+// confirms the graceful-degradation path: if Redis can't be reached, lastN
+// is left untouched instead of being reset or zeroed.
+func TestGlobalAdaptiveLimiter_RefreshInstanceCount_RedisUnreachableKeepsLastKnownN(t *testing.T) {
+	l, mr := newTestGlobalLimiter(t, 100)
+
+	l.mu.Lock()
+	l.lastN = 5
+	l.mu.Unlock()
+
+	mr.Close() // simulate Redis becoming unreachable
+
+	l.refreshInstanceCount(context.Background())
+
+	l.mu.RLock()
+	n := l.lastN
+	l.mu.RUnlock()
+	if n != 5 {
+		t.Fatalf("expected lastN to stay at the last known value (5) when Redis is unreachable, got %d", n)
+	}
+}
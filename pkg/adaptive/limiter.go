@@ -2,8 +2,11 @@ package adaptive
 
 import (
 	"sync"
+	"time"
 
 	"golang.org/x/time/rate"
+
+	"GoRateLimiter/pkg/metrics"
 )
 
 // AdaptiveLimiter manages the dynamic rate limit based on a calculated factor.
@@ -11,6 +14,10 @@ type AdaptiveLimiter struct {
 	mu                sync.RWMutex
 	BaseLimit         float64
 	underlyingLimiter *rate.Limiter
+
+	// Metrics is optional; when set, Allow/AllowOrReserve/UpdateFactor
+	// record outcomes against it.
+	Metrics *metrics.Metrics
 }
 
 // NewAdaptiveLimiter creates a new limiter with a starting rate.
@@ -25,10 +32,70 @@ func NewAdaptiveLimiter(baseLimit float64) *AdaptiveLimiter {
 
 // Allow is the primary method called by the HTTP middleware.
 func (l *AdaptiveLimiter) Allow() bool {
+	l.mu.RLock()
+	allowed := l.underlyingLimiter.Allow()
+	l.mu.RUnlock()
+
+	if l.Metrics != nil {
+		if allowed {
+			l.Metrics.RequestsAllowed.WithLabelValues("global", "adaptive").Inc()
+		} else {
+			l.Metrics.RequestsDenied.WithLabelValues("global", "adaptive", "adaptive").Inc()
+		}
+	}
+	return allowed
+}
+
+// AllowOrReserve shapes traffic instead of hard-dropping it: it reserves the
+// next available token and reports how long the caller must wait before
+// using it. If that wait exceeds maxWait, the reservation is cancelled (so
+// it doesn't consume future capacity) and ok is false, with delay set to
+// the wait the caller could have expected — callers typically surface this
+// as a Retry-After header.
+func (l *AdaptiveLimiter) AllowOrReserve(maxWait time.Duration) (ok bool, delay time.Duration) {
+	return l.AllowOrReserveN(1, maxWait)
+}
+
+// AllowOrReserveN is AllowOrReserve for a reservation of n tokens at once.
+// The n tokens are reserved atomically via a single rate.Limiter.ReserveN
+// call, so a caller weighting an expensive operation at n > 1 never risks
+// consuming some of the n tokens only to be denied on the rest.
+func (l *AdaptiveLimiter) AllowOrReserveN(n int, maxWait time.Duration) (ok bool, delay time.Duration) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	r := l.underlyingLimiter.ReserveN(time.Now(), n)
+	if !r.OK() {
+		return false, 0
+	}
+
+	delay = r.Delay()
+	if delay > maxWait {
+		r.Cancel()
+		if l.Metrics != nil {
+			l.Metrics.RequestsDenied.WithLabelValues("global", "adaptive", "adaptive").Inc()
+		}
+		return false, delay
+	}
+
+	if l.Metrics != nil {
+		l.Metrics.RequestsAllowed.WithLabelValues("global", "adaptive").Inc()
+		l.Metrics.WaitSeconds.Observe(delay.Seconds())
+	}
+	return true, delay
+}
+
+// DefaultMaxWait returns a sane shaping bound of half a token's worth of
+// wait time (1 / (2 * rate)) for routes that don't configure their own.
+func (l *AdaptiveLimiter) DefaultMaxWait() time.Duration {
 	l.mu.RLock()
 	defer l.mu.RUnlock()
 
-	return l.underlyingLimiter.Allow()
+	limit := float64(l.underlyingLimiter.Limit())
+	if limit <= 0 {
+		return 0
+	}
+	return time.Duration(float64(time.Second) / (2 * limit))
 }
 
 // UpdateFactor is the key method called by the Health Monitor to adjust the rate.
@@ -40,4 +107,9 @@ func (l *AdaptiveLimiter) UpdateFactor(factor float64) {
 
 	// Dynamically change the rate of the underlying limiter
 	l.underlyingLimiter.SetLimit(rate.Limit(newRate))
+
+	if l.Metrics != nil {
+		l.Metrics.AdaptiveFactor.Set(factor)
+		l.Metrics.EffectiveRPS.Set(newRate)
+	}
 }
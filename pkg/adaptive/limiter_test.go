@@ -0,0 +1,27 @@
+package adaptive
+
+import "testing"
+
+// TestAdaptiveLimiter_AllowOrReserveN_DeniesWithoutPartialConsumption checks
+// that a denied weighted reservation doesn't leave the bucket worse off than
+// before it was attempted — the underlying rate.Limiter reservation is
+// cancelled atomically, so grpclimit's per-method weighting (chunk0-7) can
+// safely retry a single token afterwards.
+func TestAdaptiveLimiter_AllowOrReserveN_DeniesWithoutPartialConsumption(t *testing.T) {
+	l := NewAdaptiveLimiter(2) // burst 2, rate 2/s
+
+	if !l.Allow() {
+		t.Fatal("expected the first Allow to succeed with a fresh bucket")
+	}
+
+	// One token remains. Reserving 2 at once needs to wait for the second
+	// to refill; with maxWait 0 that wait is never acceptable, so the
+	// reservation must be denied and cancelled rather than partially spent.
+	if ok, _ := l.AllowOrReserveN(2, 0); ok {
+		t.Fatal("expected the 2-token reservation to be denied")
+	}
+
+	if !l.Allow() {
+		t.Fatal("expected the remaining single token to still be usable after the denied reservation")
+	}
+}
@@ -2,23 +2,85 @@ package adaptive
 
 import (
 	"GoRateLimiter/pkg/health" // **<-- CHANGE 'your_module' TO YOUR ACTUAL MODULE NAME**
+	"GoRateLimiter/pkg/metrics"
 	"log"
+	"sync"
 	"time"
 )
 
+// Limiter is anything whose throttling factor can be adjusted by the
+// Monitor. Both AdaptiveLimiter and PerKeyLimiter implement it.
+type Limiter interface {
+	UpdateFactor(factor float64)
+}
+
+// Default PID/smoothing tuning, used unless a Monitor field is overridden
+// after construction.
+const (
+	defaultKp              = 0.6 // proportional gain
+	defaultKi              = 0.3 // integral gain
+	defaultKd              = 0.1 // derivative gain
+	defaultAlpha           = 0.3 // EWMA smoothing factor for raw samples
+	defaultIMax            = 1.0 // integral clamp, +/-
+	defaultSlewRate        = 0.2 // max fractional change in factor per tick
+	defaultHysteresisTicks = 3   // consecutive healthy ticks required before the factor is allowed to rise
+)
+
 // Monitor manages the background routine that adjusts the rate limiter.
 type Monitor struct {
-	Limiter  *AdaptiveLimiter
+	Limiter  Limiter
 	Source   health.HealthSource
 	Interval time.Duration
+
+	// Metrics is optional; when set, StartMonitoring times each health
+	// fetch against it.
+	Metrics *metrics.Metrics
+
+	// PID/smoothing tuning. See calculateFactor for how these combine.
+	Kp, Ki, Kd      float64
+	Alpha           float64
+	IMax            float64
+	SlewRate        float64
+	HysteresisTicks int
+
+	mu             sync.Mutex
+	signals        map[string]*signalState
+	lastFactor     float64
+	haveLastFactor bool
+	increaseStreak int
+}
+
+// signalState is the per-signal PID memory (one of CPU, latency, error
+// rate) carried between ticks.
+type signalState struct {
+	ewma        float64
+	integral    float64
+	lastError   float64
+	initialized bool
+}
+
+// SignalState is a read-only snapshot of one signal's PID state, exposed so
+// tests and metrics can introspect the controller without mutating it.
+type SignalState struct {
+	EWMA      float64
+	Integral  float64
+	LastError float64
 }
 
 // NewMonitor creates a new instance of the Adaptive Monitor.
-func NewMonitor(limiter *AdaptiveLimiter, source health.HealthSource, interval time.Duration) *Monitor {
+func NewMonitor(limiter Limiter, source health.HealthSource, interval time.Duration) *Monitor {
 	return &Monitor{
-		Limiter:  limiter,
-		Source:   source,
-		Interval: interval,
+		Limiter:         limiter,
+		Source:          source,
+		Interval:        interval,
+		Kp:              defaultKp,
+		Ki:              defaultKi,
+		Kd:              defaultKd,
+		Alpha:           defaultAlpha,
+		IMax:            defaultIMax,
+		SlewRate:        defaultSlewRate,
+		HysteresisTicks: defaultHysteresisTicks,
+		signals:         make(map[string]*signalState),
 	}
 }
 
@@ -29,56 +91,160 @@ func (m *Monitor) StartMonitoring() {
 
 	for range ticker.C {
 		// 1. Fetch the data using the Adapter interface
+		fetchStart := time.Now()
 		healthData, err := m.Source.FetchMetrics()
+		if m.Metrics != nil {
+			m.Metrics.HealthFetchSeconds.Observe(time.Since(fetchStart).Seconds())
+		}
 		if err != nil {
 			log.Printf("Error fetching health metrics: %v. Sticking to current rate.", err)
 			continue
 		}
 
 		// 2. Calculate the new adaptive factor
-		newFactor := calculateFactor(healthData)
+		newFactor := m.calculateFactor(healthData)
 
 		// 3. Update the Limiter
 		m.Limiter.UpdateFactor(newFactor)
 	}
 }
 
+// Signals returns a snapshot of the current PID state for every signal the
+// Monitor has observed so far, keyed by "cpu", "latency" and "error_rate".
+func (m *Monitor) Signals() map[string]SignalState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	out := make(map[string]SignalState, len(m.signals))
+	for name, s := range m.signals {
+		out[name] = SignalState{EWMA: s.ewma, Integral: s.integral, LastError: s.lastError}
+	}
+	return out
+}
+
+// Factor returns the most recently computed adaptive factor.
+func (m *Monitor) Factor() float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.lastFactor
+}
+
 // =========================================================================
-// The Core Adaptive Logic: This determines the throttling factor (F)
+// The Core Adaptive Logic: a discrete PID controller per signal, combined
+// and smoothed into the throttling factor (F)
 // =========================================================================
 
-// calculateFactor determines the throttling factor (0.0 to 1.0) based on health.
-func calculateFactor(data health.HealthData) float64 {
-	// Define SLO/SLA targets
-	const TargetCPU = 0.70       // We want to keep CPU below 70%
-	const TargetLatency = 500.0  // We want to keep P95 latency below 500ms
-	const TargetErrorRate = 0.01 // We want to keep Error Rate below 1% (0.01)
-
-	// Calculate a factor for each metric: Factor = Target / Current
+// Define SLO/SLA targets
+const (
+	TargetCPU       = 0.70  // We want to keep CPU below 70%
+	TargetLatency   = 500.0 // We want to keep P95 latency below 500ms
+	TargetErrorRate = 0.01  // We want to keep Error Rate below 1% (0.01)
+)
 
-	// 1. CPU Factor
-	cpuFactor := TargetCPU / data.CPUUtilization
+// calculateFactor determines the throttling factor (0.1 to 1.0) based on
+// health, using a discrete PID controller per signal instead of a raw
+// target/current ratio. Each signal's Prometheus sample is first EWMA
+// smoothed, then turned into a headroom error, an accumulated integral and
+// a derivative; the three combine into a per-signal factor exactly like
+// the old ratio did. The most-stressed signal still wins via min(...), but
+// the result is now slew-rate limited and gated by hysteresis so a single
+// noisy tick can't whipsaw the limit.
+func (m *Monitor) calculateFactor(data health.HealthData) float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
-	// 2. Latency Factor
-	latencyFactor := TargetLatency / data.P95LatencyMs
+	dt := m.Interval.Seconds()
 
-	// 3. Error Factor
-	errorFactor := TargetErrorRate / data.ErrorRate
+	cpuError, cpuFactor := m.updateSignalLocked("cpu", TargetCPU, data.CPUUtilization, dt)
+	latencyError, latencyFactor := m.updateSignalLocked("latency", TargetLatency, data.P95LatencyMs, dt)
+	errorError, errorFactor := m.updateSignalLocked("error_rate", TargetErrorRate, data.ErrorRate, dt)
 
-	// Find the minimum factor (the most stressed metric dictates the throttle)
-	factor := min(cpuFactor, latencyFactor, errorFactor)
+	// The most-stressed signal dictates the throttle, same as before.
+	rawFactor := min(cpuFactor, latencyFactor, errorFactor)
+	combinedError := min(cpuError, latencyError, errorError)
 
 	// Apply bounds:
 	// Cap the maximum factor at 1.0 (no throttling)
-	if factor > 1.0 {
-		return 1.0
+	if rawFactor > 1.0 {
+		rawFactor = 1.0
 	}
 	// Set a floor (e.g., 0.1) to prevent the rate from dropping to absolute zero
-	if factor < 0.1 {
-		return 0.1
+	if rawFactor < 0.1 {
+		rawFactor = 0.1
+	}
+
+	if combinedError > 0 {
+		m.increaseStreak++
+	} else {
+		m.increaseStreak = 0
+	}
+
+	if !m.haveLastFactor {
+		m.lastFactor = rawFactor
+		m.haveLastFactor = true
+		return m.lastFactor
+	}
+
+	newFactor := rawFactor
+
+	// Hysteresis: only let the factor recover upward once every signal has
+	// had headroom for HysteresisTicks consecutive ticks. Throttling down
+	// is never held back — only recovery is cautious.
+	if newFactor > m.lastFactor && m.increaseStreak < m.HysteresisTicks {
+		newFactor = m.lastFactor
 	}
 
-	return factor
+	// Slew-rate limit: the factor can't move by more than SlewRate of its
+	// previous value in a single tick, in either direction.
+	maxDelta := m.lastFactor * m.SlewRate
+	if newFactor-m.lastFactor > maxDelta {
+		newFactor = m.lastFactor + maxDelta
+	} else if m.lastFactor-newFactor > maxDelta {
+		newFactor = m.lastFactor - maxDelta
+	}
+
+	m.lastFactor = newFactor
+	return newFactor
+}
+
+// updateSignalLocked runs one signal's EWMA + PID step and returns its
+// smoothed headroom error (positive means headroom) and resulting factor.
+// Callers must hold m.mu.
+func (m *Monitor) updateSignalLocked(name string, target, sample, dt float64) (errorVal, factor float64) {
+	s := m.signals[name]
+	if s == nil {
+		s = &signalState{}
+		m.signals[name] = s
+	}
+
+	if !s.initialized {
+		s.ewma = sample
+		s.initialized = true
+	} else {
+		s.ewma = m.Alpha*sample + (1-m.Alpha)*s.ewma
+	}
+
+	errorVal = (target - s.ewma) / target
+	s.integral = clampFloat(s.integral+errorVal*dt, -m.IMax, m.IMax)
+
+	derivative := 0.0
+	if dt > 0 {
+		derivative = (errorVal - s.lastError) / dt
+	}
+	s.lastError = errorVal
+
+	factor = 1 + m.Kp*errorVal + m.Ki*s.integral + m.Kd*derivative
+	return errorVal, factor
+}
+
+func clampFloat(v, lo, hi float64) float64 {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
 }
 
 func min(a, b, c float64) float64 {
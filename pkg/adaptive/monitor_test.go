@@ -0,0 +1,84 @@
+package adaptive
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"GoRateLimiter/pkg/health"
+)
+
+func TestMonitor_CalculateFactor_Step(t *testing.T) {
+	m := NewMonitor(nil, nil, time.Second)
+	healthy := health.HealthData{CPUUtilization: 0.5, P95LatencyMs: 300, ErrorRate: 0.002}
+
+	var factor float64
+	for i := 0; i < 30; i++ {
+		factor = m.calculateFactor(healthy)
+	}
+
+	if factor < 0.9 || factor > 1.0 {
+		t.Fatalf("expected factor to converge near 1.0 under sustained healthy load, got %f", factor)
+	}
+}
+
+func TestMonitor_CalculateFactor_Ramp(t *testing.T) {
+	m := NewMonitor(nil, nil, time.Second)
+
+	prev := 1.0
+	sawDecrease := false
+	for i := 0; i < 40; i++ {
+		cpu := 0.5 + float64(i)*0.02 // ramps from well under target to well over it
+		data := health.HealthData{CPUUtilization: cpu, P95LatencyMs: 300, ErrorRate: 0.002}
+		factor := m.calculateFactor(data)
+		if factor < prev {
+			sawDecrease = true
+		}
+		prev = factor
+	}
+
+	if !sawDecrease {
+		t.Fatal("expected the factor to decrease at some point as CPU ramped past target")
+	}
+	if prev >= 1.0 {
+		t.Fatalf("expected the factor to have throttled down by the end of the ramp, got %f", prev)
+	}
+}
+
+func TestMonitor_CalculateFactor_Oscillation(t *testing.T) {
+	m := NewMonitor(nil, nil, time.Second)
+
+	var prevFactor float64
+	initialized := false
+	for i := 0; i < 60; i++ {
+		cpu := 0.5
+		if i%2 == 0 {
+			cpu = 0.95
+		}
+		data := health.HealthData{CPUUtilization: cpu, P95LatencyMs: 300, ErrorRate: 0.002}
+		factor := m.calculateFactor(data)
+
+		if initialized {
+			maxDelta := prevFactor*m.SlewRate + 1e-9
+			if math.Abs(factor-prevFactor) > maxDelta {
+				t.Fatalf("tick %d: factor jumped from %f to %f, exceeding slew bound %f", i, prevFactor, factor, maxDelta)
+			}
+		}
+		prevFactor = factor
+		initialized = true
+	}
+}
+
+func TestMonitor_Signals_ReflectsLatestState(t *testing.T) {
+	m := NewMonitor(nil, nil, time.Second)
+	m.calculateFactor(health.HealthData{CPUUtilization: 0.9, P95LatencyMs: 300, ErrorRate: 0.002})
+
+	signals := m.Signals()
+	cpu, ok := signals["cpu"]
+	if !ok {
+		t.Fatal("expected a \"cpu\" entry in Signals()")
+	}
+	if cpu.EWMA != 0.9 {
+		t.Fatalf("expected cpu EWMA to seed at the first sample (0.9), got %f", cpu.EWMA)
+	}
+}
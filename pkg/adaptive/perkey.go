@@ -0,0 +1,300 @@
+package adaptive
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"GoRateLimiter/pkg/metrics"
+)
+
+// SourceExtractor pulls a per-client identifier (IP, API key, etc.) out of
+// an inbound request so PerKeyLimiter can enforce the rate limit separately
+// for each source.
+type SourceExtractor func(*http.Request) (string, error)
+
+// DefaultSourceExtractor keys on the first address in X-Forwarded-For, if
+// present, falling back to r.RemoteAddr.
+func DefaultSourceExtractor(r *http.Request) (string, error) {
+	if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+		if idx := strings.IndexByte(fwd, ','); idx != -1 {
+			return strings.TrimSpace(fwd[:idx]), nil
+		}
+		return strings.TrimSpace(fwd), nil
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr, nil
+	}
+	return host, nil
+}
+
+// maxBuckets caps the number of per-source limiters PerKeyLimiter keeps in
+// memory at once. Once the cap is hit, the least-recently-seen bucket is
+// evicted to make room, so a flood of distinct (or spoofed) sources can't
+// exhaust memory.
+const maxBuckets = 65536
+
+// bucket pairs a per-source limiter with the last time it was used, so idle
+// sources can be garbage-collected.
+type bucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// PerKeyLimiter enforces the adaptive rate limit independently for each
+// source, instead of the single shared bucket AdaptiveLimiter uses. Sources
+// that go idle for longer than ttl are garbage-collected so a long-running
+// process doesn't accumulate one bucket per client forever.
+type PerKeyLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+
+	baseLimit    float64
+	burst        int
+	currentLimit rate.Limit
+	ttl          time.Duration
+
+	// Metrics is optional; when set, Allow/AllowOrReserve/UpdateFactor
+	// record outcomes against it.
+	Metrics *metrics.Metrics
+}
+
+// NewPerKeyLimiter creates a PerKeyLimiter starting at baseLimit
+// requests/sec, per source.
+func NewPerKeyLimiter(baseLimit float64) *PerKeyLimiter {
+	burst := int(baseLimit)
+	if burst < 1 {
+		burst = 1
+	}
+	limit := rate.Limit(baseLimit)
+
+	return &PerKeyLimiter{
+		buckets:      make(map[string]*bucket),
+		baseLimit:    baseLimit,
+		burst:        burst,
+		currentLimit: limit,
+		ttl:          idleTTL(limit, burst),
+	}
+}
+
+// idleTTL mirrors the "10 * burst/rate" rule of thumb for how long a bucket
+// can sit untouched before it's safe to forget: a source that hasn't been
+// seen for that long couldn't have meaningfully drained or still be
+// relying on its accumulated burst.
+func idleTTL(limit rate.Limit, burst int) time.Duration {
+	if limit <= 0 {
+		return 10 * time.Minute
+	}
+	ttl := time.Duration(10 * float64(burst) / float64(limit) * float64(time.Second))
+	if ttl < time.Second {
+		ttl = time.Second
+	}
+	return ttl
+}
+
+// Allow reports whether a request from the given source should be let
+// through, creating a bucket for first-time sources on demand.
+func (l *PerKeyLimiter) Allow(source string) bool {
+	l.mu.Lock()
+	b := l.getOrCreateBucketLocked(source)
+	l.mu.Unlock()
+
+	allowed := b.limiter.Allow()
+	if l.Metrics != nil {
+		if allowed {
+			l.Metrics.RequestsAllowed.WithLabelValues(source, "adaptive_per_key").Inc()
+		} else {
+			l.Metrics.RequestsDenied.WithLabelValues(source, "adaptive_per_key", "adaptive").Inc()
+		}
+	}
+	return allowed
+}
+
+// AllowOrReserve shapes traffic for source instead of hard-dropping it: it
+// reserves the source's next available token and reports how long the
+// caller must wait before using it. If that wait exceeds maxWait, the
+// reservation is cancelled and ok is false, with delay set to the wait the
+// caller could have expected — callers typically surface this as a
+// Retry-After header.
+func (l *PerKeyLimiter) AllowOrReserve(source string, maxWait time.Duration) (ok bool, delay time.Duration) {
+	l.mu.Lock()
+	b := l.getOrCreateBucketLocked(source)
+	l.mu.Unlock()
+
+	r := b.limiter.ReserveN(time.Now(), 1)
+	if !r.OK() {
+		return false, 0
+	}
+
+	delay = r.Delay()
+	if delay > maxWait {
+		r.Cancel()
+		if l.Metrics != nil {
+			l.Metrics.RequestsDenied.WithLabelValues(source, "adaptive_per_key", "adaptive").Inc()
+		}
+		return false, delay
+	}
+
+	if l.Metrics != nil {
+		l.Metrics.RequestsAllowed.WithLabelValues(source, "adaptive_per_key").Inc()
+		l.Metrics.WaitSeconds.Observe(delay.Seconds())
+	}
+	return true, delay
+}
+
+// DefaultMaxWait returns a sane shaping bound of half a token's worth of
+// wait time (1 / (2 * rate)) for source's current rate, for routes that
+// don't configure their own.
+func (l *PerKeyLimiter) DefaultMaxWait(source string) time.Duration {
+	l.mu.Lock()
+	b := l.getOrCreateBucketLocked(source)
+	l.mu.Unlock()
+
+	limit := float64(b.limiter.Limit())
+	if limit <= 0 {
+		return 0
+	}
+	return time.Duration(float64(time.Second) / (2 * limit))
+}
+
+func (l *PerKeyLimiter) getOrCreateBucketLocked(source string) *bucket {
+	now := time.Now()
+	if b, ok := l.buckets[source]; ok {
+		b.lastSeen = now
+		return b
+	}
+
+	l.evictIdleLocked(now)
+	if len(l.buckets) >= maxBuckets {
+		l.evictOldestLocked()
+	}
+
+	b := &bucket{
+		limiter:  rate.NewLimiter(l.currentLimit, l.burst),
+		lastSeen: now,
+	}
+	l.buckets[source] = b
+	return b
+}
+
+// evictIdleLocked drops every bucket that has been idle longer than ttl.
+func (l *PerKeyLimiter) evictIdleLocked(now time.Time) {
+	for source, b := range l.buckets {
+		if now.Sub(b.lastSeen) > l.ttl {
+			delete(l.buckets, source)
+		}
+	}
+}
+
+// evictOldestLocked drops the single least-recently-seen bucket. It's the
+// hard-cap fallback for when evictIdleLocked doesn't free up room because
+// every existing source is still active.
+func (l *PerKeyLimiter) evictOldestLocked() {
+	var oldestSource string
+	var oldestSeen time.Time
+	first := true
+	for source, b := range l.buckets {
+		if first || b.lastSeen.Before(oldestSeen) {
+			oldestSource, oldestSeen, first = source, b.lastSeen, false
+		}
+	}
+	if !first {
+		delete(l.buckets, oldestSource)
+	}
+}
+
+// UpdateFactor applies a new adaptive factor to every live bucket and
+// remembers it as the starting limit for buckets created afterwards.
+func (l *PerKeyLimiter) UpdateFactor(factor float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.currentLimit = rate.Limit(l.baseLimit * factor)
+	for _, b := range l.buckets {
+		b.limiter.SetLimit(l.currentLimit)
+	}
+
+	if l.Metrics != nil {
+		l.Metrics.AdaptiveFactor.Set(factor)
+		l.Metrics.EffectiveRPS.Set(float64(l.currentLimit))
+	}
+}
+
+// NewPerKeyMiddleware builds HTTP middleware that enforces limiter
+// per-source, using extractor to derive each request's source.
+func NewPerKeyMiddleware(limiter *PerKeyLimiter, extractor SourceExtractor) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			source, err := extractor(r)
+			if err != nil {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"error": "could not determine rate limit source"}`))
+				return
+			}
+
+			if !limiter.Allow(source) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusTooManyRequests)
+				w.Write([]byte(`{"error": "Rate limit exceeded. Try again later."}`))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// NewPerKeyReservationMiddleware builds HTTP middleware that shapes traffic
+// per-source instead of hard-dropping it: rather than rejecting a request
+// the moment the bucket runs dry, it reserves the next available token and
+// sleeps until it's ready, as long as that wait is within maxWait. A wait
+// that would exceed maxWait still gets a 429, now with a Retry-After header
+// so well-behaved clients know when to come back. maxWait <= 0 uses
+// limiter's DefaultMaxWait for the source's current rate.
+func NewPerKeyReservationMiddleware(limiter *PerKeyLimiter, extractor SourceExtractor, maxWait time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			source, err := extractor(r)
+			if err != nil {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"error": "could not determine rate limit source"}`))
+				return
+			}
+
+			wait := maxWait
+			if wait <= 0 {
+				wait = limiter.DefaultMaxWait(source)
+			}
+
+			ok, delay := limiter.AllowOrReserve(source, wait)
+			if !ok {
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set("Retry-After", fmt.Sprintf("%.0f", delay.Seconds()))
+				w.WriteHeader(http.StatusTooManyRequests)
+				w.Write([]byte(`{"error": "Rate limit exceeded. Try again later."}`))
+				return
+			}
+
+			if delay > 0 {
+				timer := time.NewTimer(delay)
+				defer timer.Stop()
+				select {
+				case <-timer.C:
+				case <-r.Context().Done():
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
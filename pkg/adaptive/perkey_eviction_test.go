@@ -0,0 +1,125 @@
+package adaptive
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestPerKeyLimiter_EvictIdleLocked(t *testing.T) {
+	l := NewPerKeyLimiter(10)
+	l.Allow("stale")
+	l.Allow("fresh")
+
+	l.mu.Lock()
+	l.buckets["stale"].lastSeen = time.Now().Add(-l.ttl - time.Second)
+	l.evictIdleLocked(time.Now())
+	_, staleStillThere := l.buckets["stale"]
+	_, freshStillThere := l.buckets["fresh"]
+	l.mu.Unlock()
+
+	if staleStillThere {
+		t.Fatal("expected the bucket idle past ttl to be evicted")
+	}
+	if !freshStillThere {
+		t.Fatal("expected the recently-seen bucket to survive")
+	}
+}
+
+func TestPerKeyLimiter_EvictOldestLocked(t *testing.T) {
+	l := NewPerKeyLimiter(10)
+	l.Allow("a")
+	l.Allow("b")
+	l.Allow("c")
+
+	now := time.Now()
+	l.mu.Lock()
+	l.buckets["a"].lastSeen = now.Add(-3 * time.Second)
+	l.buckets["b"].lastSeen = now.Add(-2 * time.Second)
+	l.buckets["c"].lastSeen = now.Add(-1 * time.Second)
+	l.evictOldestLocked()
+	_, aThere := l.buckets["a"]
+	_, bThere := l.buckets["b"]
+	_, cThere := l.buckets["c"]
+	l.mu.Unlock()
+
+	if aThere {
+		t.Fatal("expected the least-recently-seen bucket (a) to be evicted")
+	}
+	if !bThere || !cThere {
+		t.Fatal("expected the more recently-seen buckets to survive")
+	}
+}
+
+// TestPerKeyLimiter_MaxBucketsCapsMemory drives getOrCreateBucketLocked past
+// maxBuckets and asserts the hard cap holds: the oldest bucket is evicted to
+// make room instead of letting the map grow without bound.
+func TestPerKeyLimiter_MaxBucketsCapsMemory(t *testing.T) {
+	l := NewPerKeyLimiter(10)
+	// Isolate the hard-cap eviction path from idle-TTL eviction, and
+	// populate buckets directly rather than through Allow: going through
+	// Allow maxBuckets times is O(maxBuckets^2), since every insert scans
+	// the whole map for idle entries.
+	l.ttl = time.Hour
+	now := time.Now()
+	for i := 0; i < maxBuckets; i++ {
+		l.buckets[fmt.Sprintf("k%d", i)] = &bucket{
+			limiter:  rate.NewLimiter(l.currentLimit, l.burst),
+			lastSeen: now.Add(time.Duration(i) * time.Millisecond),
+		}
+	}
+
+	l.mu.Lock()
+	size := len(l.buckets)
+	_, firstStillThere := l.buckets["k0"]
+	l.mu.Unlock()
+	if size != maxBuckets {
+		t.Fatalf("expected exactly maxBuckets (%d) buckets, got %d", maxBuckets, size)
+	}
+	if !firstStillThere {
+		t.Fatal("did not expect eviction before the cap was reached")
+	}
+
+	l.Allow("overflow")
+
+	l.mu.Lock()
+	size = len(l.buckets)
+	_, firstStillThere = l.buckets["k0"]
+	_, overflowThere := l.buckets["overflow"]
+	l.mu.Unlock()
+
+	if size != maxBuckets {
+		t.Fatalf("expected the bucket count to stay capped at %d, got %d", maxBuckets, size)
+	}
+	if firstStillThere {
+		t.Fatal("expected the oldest bucket (k0) to be evicted to make room")
+	}
+	if !overflowThere {
+		t.Fatal("expected the new source to get its own bucket")
+	}
+}
+
+func TestPerKeyLimiter_UpdateFactorPropagatesToBuckets(t *testing.T) {
+	l := NewPerKeyLimiter(100)
+	l.Allow("client")
+
+	l.UpdateFactor(0.5)
+
+	l.mu.Lock()
+	gotLimit := float64(l.buckets["client"].limiter.Limit())
+	l.mu.Unlock()
+	if gotLimit != 50 {
+		t.Fatalf("expected the existing bucket's limit to update to 50, got %f", gotLimit)
+	}
+
+	// A bucket created after UpdateFactor should start at the new rate too.
+	l.Allow("late-client")
+	l.mu.Lock()
+	gotLateLimit := float64(l.buckets["late-client"].limiter.Limit())
+	l.mu.Unlock()
+	if gotLateLimit != 50 {
+		t.Fatalf("expected a bucket created after UpdateFactor to start at 50, got %f", gotLateLimit)
+	}
+}
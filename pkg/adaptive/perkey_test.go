@@ -0,0 +1,140 @@
+package adaptive
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func constantSource(_ *http.Request) (string, error) {
+	return "client-a", nil
+}
+
+func failingSource(_ *http.Request) (string, error) {
+	return "", errors.New("no source")
+}
+
+// TestNewPerKeyMiddleware_ContentTypeHeader drives both error paths of
+// NewPerKeyMiddleware through a real httptest.Server so a Content-Type set
+// after WriteHeader — which net/http silently drops — would actually be
+// caught.
+func TestNewPerKeyMiddleware_ContentTypeHeader(t *testing.T) {
+	t.Run("bad source extractor", func(t *testing.T) {
+		limiter := NewPerKeyLimiter(10)
+		handler := NewPerKeyMiddleware(limiter, failingSource)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+		srv := httptest.NewServer(handler)
+		defer srv.Close()
+
+		resp, err := http.Get(srv.URL)
+		if err != nil {
+			t.Fatalf("request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("expected 400, got %d", resp.StatusCode)
+		}
+		if got := resp.Header.Get("Content-Type"); got != "application/json" {
+			t.Fatalf("expected Content-Type application/json, got %q", got)
+		}
+	})
+
+	t.Run("rate limited", func(t *testing.T) {
+		limiter := NewPerKeyLimiter(1) // 1 req/s, burst 1
+		handler := NewPerKeyMiddleware(limiter, constantSource)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+		srv := httptest.NewServer(handler)
+		defer srv.Close()
+
+		resp, err := http.Get(srv.URL)
+		if err != nil {
+			t.Fatalf("first request: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected first request to succeed, got %d", resp.StatusCode)
+		}
+
+		resp, err = http.Get(srv.URL)
+		if err != nil {
+			t.Fatalf("second request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusTooManyRequests {
+			t.Fatalf("expected 429, got %d", resp.StatusCode)
+		}
+		if got := resp.Header.Get("Content-Type"); got != "application/json" {
+			t.Fatalf("expected Content-Type application/json, got %q", got)
+		}
+	})
+}
+
+// TestNewPerKeyReservationMiddleware_BadSourceContentType covers the other
+// early-return path of NewPerKeyReservationMiddleware that bb43473 didn't
+// touch: a failing SourceExtractor.
+func TestNewPerKeyReservationMiddleware_BadSourceContentType(t *testing.T) {
+	limiter := NewPerKeyLimiter(10)
+	handler := NewPerKeyReservationMiddleware(limiter, failingSource, 0)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Content-Type"); got != "application/json" {
+		t.Fatalf("expected Content-Type application/json, got %q", got)
+	}
+}
+
+// TestNewPerKeyReservationMiddleware_RetryAfterHeader drives the middleware
+// through a real httptest.Server (not just an httptest.ResponseRecorder) so
+// a header set after WriteHeader — which net/http silently drops — would
+// actually be caught.
+func TestNewPerKeyReservationMiddleware_RetryAfterHeader(t *testing.T) {
+	limiter := NewPerKeyLimiter(1) // 1 req/s, burst 1
+	handler := NewPerKeyReservationMiddleware(limiter, constantSource, 0)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	// First request consumes the only token.
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("first request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected first request to succeed, got %d", resp.StatusCode)
+	}
+
+	// Second request arrives immediately after, well inside the bucket's
+	// refill window, so DefaultMaxWait (1/(2*rate) = 500ms) can't cover the
+	// ~1s wait for the next token and the request should be denied.
+	resp, err = http.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("second request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Retry-After"); got == "" {
+		t.Fatal("expected a non-empty Retry-After header on the real HTTP response")
+	}
+}
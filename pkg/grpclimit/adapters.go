@@ -0,0 +1,51 @@
+package grpclimit
+
+import (
+	"context"
+	"time"
+
+	"GoRateLimiter/pkg/adaptive"
+	limiter "GoRateLimiter/pkg/static_limiter"
+)
+
+// AdaptiveAllower adapts an *adaptive.AdaptiveLimiter to Allower. The
+// underlying limiter enforces one shared budget regardless of caller, so key
+// is ignored; MaxWait is forwarded to AllowOrReserve, defaulting to the
+// limiter's own DefaultMaxWait when zero.
+type AdaptiveAllower struct {
+	Limiter *adaptive.AdaptiveLimiter
+	MaxWait time.Duration
+}
+
+// Allow implements Allower.
+func (a AdaptiveAllower) Allow(ctx context.Context, key string) (bool, time.Duration, error) {
+	return a.AllowN(ctx, key, 1)
+}
+
+// AllowN implements WeightedAllower: it reserves n tokens atomically via
+// AllowOrReserveN, so NewMethodOverrides can apply a per-method weight
+// without risking a partial reservation on denial.
+func (a AdaptiveAllower) AllowN(ctx context.Context, key string, n int) (bool, time.Duration, error) {
+	maxWait := a.MaxWait
+	if maxWait <= 0 {
+		maxWait = a.Limiter.DefaultMaxWait()
+	}
+	ok, delay := a.Limiter.AllowOrReserveN(n, maxWait)
+	return ok, delay, nil
+}
+
+// StaticAllower adapts a *limiter.Limiter (the Redis token-bucket +
+// sliding-window hybrid in pkg/static_limiter) to Allower, keyed by the
+// caller identity.
+type StaticAllower struct {
+	Limiter *limiter.Limiter
+}
+
+// Allow implements Allower. limiter.Limiter doesn't reserve ahead of
+// time, so a denial carries no retry-delay estimate.
+func (a StaticAllower) Allow(ctx context.Context, key string) (bool, time.Duration, error) {
+	if a.Limiter.Allow(key) {
+		return true, 0, nil
+	}
+	return false, 0, nil
+}
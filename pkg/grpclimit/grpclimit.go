@@ -0,0 +1,95 @@
+// Package grpclimit adapts the project's existing limiters to gRPC, so a
+// service using net/http middleware for one route and gRPC for another can
+// share the same rate limit instead of running two independent ones.
+package grpclimit
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// Allower is the minimal interface grpclimit needs from a limiter: check
+// whether key may proceed and, if not, how long the caller should wait
+// before retrying. adaptive.AdaptiveLimiter and limiter.Limiter already
+// have an Allow method with a different signature each, so they're wrapped
+// by AdaptiveAllower and StaticAllower in adapters.go rather than implementing this
+// directly.
+type Allower interface {
+	Allow(ctx context.Context, key string) (bool, time.Duration, error)
+}
+
+// extractKey derives the caller identity used as the limiter key: the
+// x-api-key metadata header if present, otherwise the peer address.
+func extractKey(ctx context.Context) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if vals := md.Get("x-api-key"); len(vals) > 0 && vals[0] != "" {
+			return vals[0]
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		return p.Addr.String()
+	}
+	return "unknown"
+}
+
+// deniedError builds the ResourceExhausted status returned on denial, with a
+// RetryInfo detail carrying the suggested wait.
+func deniedError(delay time.Duration) error {
+	st := status.New(codes.ResourceExhausted, "rate limit exceeded")
+	withDetails, err := st.WithDetails(&errdetails.RetryInfo{
+		RetryDelay: durationpb.New(delay),
+	})
+	if err != nil {
+		return st.Err()
+	}
+	return withDetails.Err()
+}
+
+// checkAllow runs l.Allow for key and turns a hard error into an Internal
+// status, so callers only have to handle the ok/denied case.
+func checkAllow(ctx context.Context, l Allower, key string) (bool, time.Duration, error) {
+	ok, delay, err := l.Allow(ctx, key)
+	if err != nil {
+		return false, 0, status.Errorf(codes.Internal, "rate limiter error: %v", err)
+	}
+	return ok, delay, nil
+}
+
+// UnaryServerInterceptor rate-limits unary RPCs using l, keyed by caller
+// identity (the x-api-key metadata header, falling back to the peer
+// address). Wrap l with NewMethodOverrides to apply per-method multipliers.
+func UnaryServerInterceptor(l Allower) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ok, delay, err := checkAllow(ctx, l, extractKey(ctx))
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, deniedError(delay)
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor rate-limits streaming RPCs the same way
+// UnaryServerInterceptor does, checked once at stream setup.
+func StreamServerInterceptor(l Allower) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ok, delay, err := checkAllow(ss.Context(), l, extractKey(ss.Context()))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return deniedError(delay)
+		}
+		return handler(srv, ss)
+	}
+}
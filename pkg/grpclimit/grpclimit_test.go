@@ -0,0 +1,128 @@
+package grpclimit
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// fakeAllower lets tests control Allow's outcome without a real limiter. It
+// also implements WeightedAllower so it can back NewMethodOverrides.
+type fakeAllower struct {
+	allow   bool
+	delay   time.Duration
+	calls   int
+	lastKey string
+	lastN   int
+}
+
+func (f *fakeAllower) Allow(ctx context.Context, key string) (bool, time.Duration, error) {
+	return f.AllowN(ctx, key, 1)
+}
+
+func (f *fakeAllower) AllowN(ctx context.Context, key string, n int) (bool, time.Duration, error) {
+	f.calls++
+	f.lastKey = key
+	f.lastN = n
+	return f.allow, f.delay, nil
+}
+
+// dialHealthServer starts a bufconn-backed gRPC server with the given
+// interceptor registered and returns a connected Health client plus a
+// cleanup func.
+func dialHealthServer(t *testing.T, interceptor grpc.UnaryServerInterceptor) (grpc_health_v1.HealthClient, func()) {
+	t.Helper()
+
+	lis := bufconn.Listen(1024 * 1024)
+	srv := grpc.NewServer(grpc.UnaryInterceptor(interceptor))
+	grpc_health_v1.RegisterHealthServer(srv, &grpc_health_v1.UnimplementedHealthServer{})
+	go srv.Serve(lis)
+
+	dialer := func(context.Context, string) (net.Conn, error) { return lis.Dial() }
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(dialer),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("dialing bufconn server: %v", err)
+	}
+
+	return grpc_health_v1.NewHealthClient(conn), func() {
+		conn.Close()
+		srv.Stop()
+	}
+}
+
+func TestUnaryServerInterceptor_Allows(t *testing.T) {
+	allower := &fakeAllower{allow: true}
+	client, cleanup := dialHealthServer(t, UnaryServerInterceptor(allower))
+	defer cleanup()
+
+	// UnimplementedHealthServer rejects with codes.Unimplemented, which is
+	// only reachable if the interceptor let the call through.
+	_, err := client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	if status.Code(err) != codes.Unimplemented {
+		t.Fatalf("expected the call to reach the handler (Unimplemented), got: %v", err)
+	}
+	if allower.calls != 1 {
+		t.Fatalf("expected exactly one Allow call, got %d", allower.calls)
+	}
+}
+
+func TestUnaryServerInterceptor_Denies(t *testing.T) {
+	allower := &fakeAllower{allow: false, delay: 2 * time.Second}
+	client, cleanup := dialHealthServer(t, UnaryServerInterceptor(allower))
+	defer cleanup()
+
+	_, err := client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("expected ResourceExhausted, got: %v", err)
+	}
+
+	st, _ := status.FromError(err)
+	if len(st.Details()) == 0 {
+		t.Fatal("expected a RetryInfo detail on the denied response")
+	}
+}
+
+func TestMethodOverrides_Weight(t *testing.T) {
+	overrides := MethodOverrides{"/svc/Expensive": 0.5, "/svc/Cheap": 2}
+
+	if w := overrides.weight("/svc/Expensive"); w != 2 {
+		t.Fatalf("expected weight 2 for a 0.5 multiplier, got %d", w)
+	}
+	if w := overrides.weight("/svc/Cheap"); w != 1 {
+		t.Fatalf("expected weight 1 for a multiplier >= 1, got %d", w)
+	}
+	if w := overrides.weight("/svc/Unlisted"); w != 1 {
+		t.Fatalf("expected weight 1 for an unlisted method, got %d", w)
+	}
+}
+
+// TestNewMethodOverrides_AppliesWeightAtomically asserts the override
+// wrapper spends the method's weight through a single AllowN(n) call rather
+// than looping Allow, so a denial can never leave some of the weight's
+// tokens already consumed.
+func TestNewMethodOverrides_AppliesWeightAtomically(t *testing.T) {
+	allower := &fakeAllower{allow: true}
+	wrapped := NewMethodOverrides(allower, MethodOverrides{grpc_health_v1.Health_Check_FullMethodName: 0.5})
+
+	client, cleanup := dialHealthServer(t, UnaryServerInterceptor(wrapped))
+	defer cleanup()
+
+	client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	if allower.calls != 1 {
+		t.Fatalf("expected exactly one AllowN call (atomic), got %d", allower.calls)
+	}
+	if allower.lastN != 2 {
+		t.Fatalf("expected the 0.5 multiplier to request weight 2, got %d", allower.lastN)
+	}
+}
@@ -0,0 +1,65 @@
+package grpclimit
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// MethodOverrides maps a full gRPC method name (e.g.
+// "/pkg.Service/Method") to a limit multiplier. A multiplier below 1 makes
+// the method more expensive by charging it ceil(1/multiplier) units of the
+// limiter's budget per call, approximating a stricter per-method limit. A
+// multiplier of 1, or a method with no entry, costs exactly one unit. Allower only
+// reports pass/fail rather than a fractional budget, so multipliers at or
+// above 1 can't loosen the limit any further and are treated the same as 1.
+type MethodOverrides map[string]float64
+
+func (o MethodOverrides) weight(fullMethod string) int {
+	multiplier, ok := o[fullMethod]
+	if !ok || multiplier <= 0 || multiplier >= 1 {
+		return 1
+	}
+	w := int(math.Ceil(1 / multiplier))
+	if w < 1 {
+		w = 1
+	}
+	return w
+}
+
+// WeightedAllower is an optional extension of Allower for limiters that can
+// check and consume more than one unit in a single atomic call (see
+// adaptive.AdaptiveLimiter.AllowOrReserveN). NewMethodOverrides requires it
+// to apply a weight above 1: issuing weight separate Allow calls instead
+// would let an early call consume a real token only for a later one in the
+// same loop to be denied, burning budget the rejected RPC never got to use.
+type WeightedAllower interface {
+	AllowN(ctx context.Context, key string, n int) (bool, time.Duration, error)
+}
+
+// methodOverrideAllower wraps a WeightedAllower, applying overrides'
+// per-method weight via a single atomic AllowN(n) call.
+type methodOverrideAllower struct {
+	base      WeightedAllower
+	overrides MethodOverrides
+}
+
+// NewMethodOverrides wraps base so calls against a method listed in
+// overrides cost weight units of base's budget instead of one, where weight
+// is derived from the method's multiplier. The full method name is read
+// from ctx via grpc.Method, so the result is only useful inside a gRPC
+// interceptor chain. Pass the result to UnaryServerInterceptor or
+// StreamServerInterceptor in place of base.
+func NewMethodOverrides(base WeightedAllower, overrides MethodOverrides) Allower {
+	return methodOverrideAllower{base: base, overrides: overrides}
+}
+
+func (a methodOverrideAllower) Allow(ctx context.Context, key string) (bool, time.Duration, error) {
+	weight := 1
+	if fullMethod, ok := grpc.Method(ctx); ok {
+		weight = a.overrides.weight(fullMethod)
+	}
+	return a.base.AllowN(ctx, key, weight)
+}
@@ -0,0 +1,63 @@
+// Package metrics exposes the Prometheus collectors GoRateLimiter records
+// about its own behavior (as opposed to the metrics it consumes to drive
+// the adaptive controller, see pkg/health).
+package metrics
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// Metrics bundles every collector GoRateLimiter exposes about itself.
+type Metrics struct {
+	RequestsAllowed *prometheus.CounterVec
+	RequestsDenied  *prometheus.CounterVec
+	AdaptiveFactor  prometheus.Gauge
+	EffectiveRPS    prometheus.Gauge
+
+	WaitSeconds        prometheus.Histogram
+	HealthFetchSeconds prometheus.Histogram
+}
+
+// Register creates GoRateLimiter's self-observability collectors and
+// registers them against reg, so the metrics can be embedded in an
+// existing app's registry instead of always claiming the global default
+// one.
+func Register(reg prometheus.Registerer) *Metrics {
+	m := &Metrics{
+		RequestsAllowed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gorate_requests_allowed_total",
+			Help: "Total requests allowed through a rate limiter.",
+		}, []string{"source", "limiter"}),
+		RequestsDenied: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gorate_requests_denied_total",
+			Help: "Total requests denied by a rate limiter, by reason.",
+		}, []string{"source", "limiter", "reason"}),
+		AdaptiveFactor: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "gorate_adaptive_factor",
+			Help: "Current adaptive throttling factor (1.0 means no throttling).",
+		}),
+		EffectiveRPS: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "gorate_effective_rps",
+			Help: "Current effective requests/sec limit after applying the adaptive factor.",
+		}),
+		WaitSeconds: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "gorate_wait_seconds",
+			Help:    "Time callers spent waiting on a reservation before being served.",
+			Buckets: prometheus.DefBuckets,
+		}),
+		HealthFetchSeconds: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "gorate_health_fetch_seconds",
+			Help:    "Time spent fetching health metrics for the adaptive monitor.",
+			Buckets: prometheus.DefBuckets,
+		}),
+	}
+
+	reg.MustRegister(
+		m.RequestsAllowed,
+		m.RequestsDenied,
+		m.AdaptiveFactor,
+		m.EffectiveRPS,
+		m.WaitSeconds,
+		m.HealthFetchSeconds,
+	)
+
+	return m
+}
@@ -3,9 +3,14 @@ package limiter
 import (
 	"context"
 	"fmt"
+	"log"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-redis/redis/v8"
+
+	"GoRateLimiter/pkg/metrics"
 )
 
 // Limiter struct holds config for both Token Bucket and SWC.
@@ -20,14 +25,104 @@ type Limiter struct {
 	// Sliding Window Counter Configuration (for long-term rate control)
 	SWCLimit  int64         // Max requests in window (N_swc)
 	SWCWindow time.Duration // Duration of the window (T_swc)
+
+	scriptSHAMu sync.RWMutex
+	scriptSHA   string // SHA1 of allowScript, cached after SCRIPT LOAD; guarded by scriptSHAMu since concurrent Allow calls can all re-cache it after a NOSCRIPT
+
+	// Metrics is optional; when set, Allow records outcomes against it.
+	Metrics *metrics.Metrics
+}
+
+// getScriptSHA returns the cached script SHA, if any.
+func (l *Limiter) getScriptSHA() string {
+	l.scriptSHAMu.RLock()
+	defer l.scriptSHAMu.RUnlock()
+	return l.scriptSHA
+}
+
+// setScriptSHA updates the cached script SHA.
+func (l *Limiter) setScriptSHA(sha string) {
+	l.scriptSHAMu.Lock()
+	defer l.scriptSHAMu.Unlock()
+	l.scriptSHA = sha
 }
 
+// allowScript refills the token bucket, checks the sliding window counter,
+// and — only if both checks pass — consumes a token and increments the
+// window count, all as a single atomic EVAL. Doing this in Lua instead of
+// separate GET/Decr/Incr round-trips closes the race where two concurrent
+// requests could each observe spare capacity and both be let through.
+//
+// KEYS:  {tb_tokens, tb_refill, swc_current, swc_previous}
+// ARGV:  {now_ns, capacity, refill_ns, swc_limit, swc_window_ns, overlap_numerator, overlap_denominator}
+// Reply: {allowed (0/1), remaining_tokens, estimated_count}
+// estimated_count is -1 when denied by the token bucket rather than the SWC.
+const allowScript = `
+local tb_tokens_key, tb_refill_key, swc_current_key, swc_previous_key = KEYS[1], KEYS[2], KEYS[3], KEYS[4]
+local now_ns = tonumber(ARGV[1])
+local capacity = tonumber(ARGV[2])
+local refill_ns = tonumber(ARGV[3])
+local swc_limit = tonumber(ARGV[4])
+local swc_window_ns = tonumber(ARGV[5])
+local overlap_num = tonumber(ARGV[6])
+local overlap_den = tonumber(ARGV[7])
+
+-- 1. Refill the token bucket.
+local current_tokens = tonumber(redis.call("GET", tb_tokens_key))
+if current_tokens == nil then
+	current_tokens = capacity
+end
+local last_refill = tonumber(redis.call("GET", tb_refill_key))
+if last_refill == nil then
+	last_refill = now_ns
+end
+
+local tokens_to_add = math.floor((now_ns - last_refill) / refill_ns)
+local new_tokens = current_tokens + tokens_to_add
+if new_tokens > capacity then
+	new_tokens = capacity
+end
+local new_last_refill = last_refill + tokens_to_add * refill_ns
+
+redis.call("SET", tb_tokens_key, new_tokens, "EX", 7200)
+redis.call("SET", tb_refill_key, new_last_refill, "EX", 7200)
+
+if new_tokens < 1 then
+	return {0, new_tokens, -1}
+end
+
+-- 2. Sliding window counter check.
+local current_count = tonumber(redis.call("GET", swc_current_key))
+if current_count == nil then
+	current_count = 0
+end
+local previous_count = tonumber(redis.call("GET", swc_previous_key))
+if previous_count == nil then
+	previous_count = 0
+end
+
+local overlap = overlap_num / overlap_den
+local estimated = math.floor(previous_count * overlap) + current_count
+
+if estimated >= swc_limit then
+	return {0, new_tokens, estimated}
+end
+
+-- 3. Both checks passed: consume a token and record the request.
+new_tokens = new_tokens - 1
+redis.call("SET", tb_tokens_key, new_tokens, "EX", 7200)
+redis.call("INCR", swc_current_key)
+redis.call("EXPIRE", swc_current_key, math.floor(swc_window_ns / 1e9) + 60)
+
+return {1, new_tokens, estimated + 1}
+`
+
 // NewLimiter is the constructor.
 func NewLimiter(rdb *redis.Client, ctx context.Context) *Limiter {
 	// Configuration:
 	// TB: Max burst of 10 requests. Refills 1 token every 6 seconds (10/min rate).
 	// SWC: Limit of 100 requests per 60 minutes.
-	return &Limiter{
+	l := &Limiter{
 		Client:         rdb,
 		Ctx:            ctx,
 		BucketCapacity: 10,
@@ -35,6 +130,14 @@ func NewLimiter(rdb *redis.Client, ctx context.Context) *Limiter {
 		SWCLimit:       100,
 		SWCWindow:      time.Minute * 60, // 60 minutes
 	}
+
+	if sha, err := rdb.ScriptLoad(ctx, allowScript).Result(); err != nil {
+		log.Printf("[LIMITER] could not preload Lua script, will EVAL on demand: %v", err)
+	} else {
+		l.setScriptSHA(sha)
+	}
+
+	return l
 }
 
 // Key generates the unique key for the SWC window in Redis.
@@ -44,128 +147,97 @@ func (l *Limiter) Key(identifier string, windowTime time.Time, prefix string) st
 	return fmt.Sprintf("%s:%s:%d", prefix, identifier, windowStart.Unix())
 }
 
-// refillBucket is the O(1) Token Bucket logic.
-// It calculates the new token count based on time elapsed since last check.
-func (l *Limiter) refillBucket(bucketKey string, lastRefillKey string) (int64, error) {
-	// 1. Get current token count and last refill time atomically
-	pipe := l.Client.Pipeline()
-	currentTokensCmd := pipe.Get(l.Ctx, bucketKey)
-	lastRefillTimeCmd := pipe.Get(l.Ctx, lastRefillKey)
-	_, err := pipe.Exec(l.Ctx)
-	if err != nil && err != redis.Nil {
-		return 0, err
-	}
+// Allow implements the Token Bucket + Sliding Window Counter hybrid logic
+// as a single atomic Lua script, so concurrent callers can't race each
+// other into both observing spare capacity.
+func (l *Limiter) Allow(identifier string) bool {
+	bucketKey := fmt.Sprintf("tb_tokens:%s", identifier)
+	lastRefillKey := fmt.Sprintf("tb_refill:%s", identifier)
 
 	now := time.Now()
+	currentWindowKey := l.Key(identifier, now, "swc_count")
+	previousWindowKey := l.Key(identifier, now.Add(-l.SWCWindow), "swc_count")
 
-	currentTokens, _ := currentTokensCmd.Int64()
-	lastRefillTimeUnix, _ := lastRefillTimeCmd.Int64()
-
-	// Handle Initial State (First Request)
-	if currentTokensCmd.Err() == redis.Nil {
-		currentTokens = l.BucketCapacity
-	}
-	if lastRefillTimeCmd.Err() == redis.Nil {
-		lastRefillTimeUnix = now.UnixNano()
+	// Calculate Overlap Percentage (as a fraction, passed to Lua as
+	// numerator/denominator to avoid re-deriving it with Lua's own
+	// float handling).
+	timeElapsedInCurrentWindow := now.Sub(now.Truncate(l.SWCWindow))
+	overlapNumerator := float64(l.SWCWindow - timeElapsedInCurrentWindow)
+	overlapDenominator := float64(l.SWCWindow)
+
+	keys := []string{bucketKey, lastRefillKey, currentWindowKey, previousWindowKey}
+	argv := []interface{}{
+		now.UnixNano(),
+		l.BucketCapacity,
+		l.RefillRate.Nanoseconds(),
+		l.SWCLimit,
+		l.SWCWindow.Nanoseconds(),
+		overlapNumerator,
+		overlapDenominator,
 	}
 
-	lastRefillTime := time.Unix(0, lastRefillTimeUnix)
-
-	// 2. Calculate tokens to add
-	timeElapsed := now.Sub(lastRefillTime)
-	tokensToAdd := int64(timeElapsed.Nanoseconds() / l.RefillRate.Nanoseconds())
-
-	newTokens := currentTokens + tokensToAdd
-
-	// 3. Clamp newTokens at max capacity
-	if newTokens > l.BucketCapacity {
-		newTokens = l.BucketCapacity
+	allowed, remaining, estimated, err := l.runScript(keys, argv)
+	if err != nil {
+		fmt.Printf("[LIMITER ERROR] Allowing request: %v\n", err)
+		return true // Fail safe
 	}
 
-	// 4. Calculate the new 'Last Refill Time' (advancing it only by the time used for refilling)
-	newLastRefillTime := lastRefillTime.Add(time.Duration(tokensToAdd) * l.RefillRate)
-
-	// 5. Update Redis with the new state (Atomic Write & Expiration)
-	pipe = l.Client.Pipeline()
-	pipe.Set(l.Ctx, bucketKey, newTokens, 0)
-	pipe.Set(l.Ctx, lastRefillKey, newLastRefillTime.UnixNano(), 0)
-
-	// Set long expiration to clean up inactive users
-	pipe.Expire(l.Ctx, bucketKey, time.Hour*2)
-	pipe.Expire(l.Ctx, lastRefillKey, time.Hour*2)
-
-	_, err = pipe.Exec(l.Ctx)
-	if err != nil && err != redis.Nil {
-		return 0, err
+	if allowed {
+		fmt.Printf("[ALLOWED] ID: %s. Est. Count: %d/%d (Tokens Left: %d)\n",
+			identifier, estimated, l.SWCLimit, remaining)
+		if l.Metrics != nil {
+			l.Metrics.RequestsAllowed.WithLabelValues(identifier, "static").Inc()
+		}
+		return true
 	}
 
-	// Return the tokens BEFORE consumption
-	return newTokens, nil
+	reason := "swc"
+	if estimated < 0 {
+		reason = "token_bucket"
+		fmt.Printf("[DENIED - TB] ID: %s. No tokens available for burst limit.\n", identifier)
+	} else {
+		fmt.Printf("[DENIED - SWC] ID: %s. Exceeded long-term rate limit of %d (Est. %d).\n",
+			identifier, l.SWCLimit, estimated)
+	}
+	if l.Metrics != nil {
+		l.Metrics.RequestsDenied.WithLabelValues(identifier, "static", reason).Inc()
+	}
+	return false
 }
 
-// Allow implements the Token Bucket + Sliding Window Counter hybrid logic.
-func (l *Limiter) Allow(identifier string) bool {
-	// --- CHECK 1: TOKEN BUCKET (Burst Defense) ---
-	bucketKey := fmt.Sprintf("tb_tokens:%s", identifier)
-	lastRefillKey := fmt.Sprintf("tb_refill:%s", identifier)
+// runScript executes allowScript via EVALSHA, transparently falling back to
+// EVAL (and re-caching the SHA) when Redis doesn't have the script loaded,
+// e.g. right after a Redis restart.
+func (l *Limiter) runScript(keys []string, argv []interface{}) (allowed bool, remaining, estimated int64, err error) {
+	var res interface{}
 
-	currentTokens, err := l.refillBucket(bucketKey, lastRefillKey)
+	sha := l.getScriptSHA()
+	if sha != "" {
+		res, err = l.Client.EvalSha(l.Ctx, sha, keys, argv...).Result()
+	}
+	if sha == "" || (err != nil && strings.HasPrefix(err.Error(), "NOSCRIPT")) {
+		if newSHA, loadErr := l.Client.ScriptLoad(l.Ctx, allowScript).Result(); loadErr == nil {
+			l.setScriptSHA(newSHA)
+		}
+		res, err = l.Client.Eval(l.Ctx, allowScript, keys, argv...).Result()
+	}
 	if err != nil {
-		fmt.Printf("[TOKEN BUCKET ERROR] Allowing request: %v\n", err)
-		return true // Fail safe
+		return false, 0, 0, err
 	}
 
-	if currentTokens < 1 {
-		fmt.Printf("[DENIED - TB] ID: %s. No tokens available for burst limit.\n", identifier)
-		return false // DENY due to burst limit exhaustion
+	vals, ok := res.([]interface{})
+	if !ok || len(vals) != 3 {
+		return false, 0, 0, fmt.Errorf("unexpected script reply: %#v", res)
 	}
 
-	// --- CHECK 2: SLIDING WINDOW COUNTER (Long-Term Rate Defense) ---
-	now := time.Now()
-	currentWindowKey := l.Key(identifier, now, "swc_count")
-	previousWindowKey := l.Key(identifier, now.Add(-l.SWCWindow), "swc_count")
-
-	// Calculate Overlap Percentage
-	timeElapsedInCurrentWindow := now.Sub(now.Truncate(l.SWCWindow))
-	overlap := 1.0 - (float64(timeElapsedInCurrentWindow) / float64(l.SWCWindow))
-
-	// Fetch counts using Pipelining for O(1) efficiency
-	pipe := l.Client.Pipeline()
-	currentCountCmd := pipe.Get(l.Ctx, currentWindowKey)
-	previousCountCmd := pipe.Get(l.Ctx, previousWindowKey)
-	_, err = pipe.Exec(l.Ctx)
-	if err != nil && err != redis.Nil {
-		fmt.Printf("[SWC ERROR] Allowing request: %v\n", err)
-		return true // Fail safe
+	nums := make([]int64, len(vals))
+	for i, v := range vals {
+		n, ok := v.(int64)
+		if !ok {
+			return false, 0, 0, fmt.Errorf("unexpected script reply element %#v", v)
+		}
+		nums[i] = n
 	}
 
-	currentCount, _ := currentCountCmd.Int64()
-	previousCount, _ := previousCountCmd.Int64()
-
-	// Calculate the Estimated Count
-	estimatedCount := int64(float64(previousCount)*overlap) + currentCount
-
-	// --- FINAL DECISION ---
-	if estimatedCount < l.SWCLimit {
-		// ALLOWED: Deduct 1 token and increment the SWC counter.
-
-		// 1. Token Bucket consumption (Deduct 1 token)
-		l.Client.Decr(l.Ctx, bucketKey)
-
-		// 2. SWC increment
-		l.Client.Incr(l.Ctx, currentWindowKey)
-
-		// 3. Set expiration on the SWC key
-		l.Client.Expire(l.Ctx, currentWindowKey, l.SWCWindow+time.Minute)
-
-		fmt.Printf("[ALLOWED] ID: %s. Est. Count: %d/%d (Tokens Left: %d)\n",
-			identifier, estimatedCount+1, l.SWCLimit, currentTokens-1)
-		return true
-
-	} else {
-		// DENIED
-		fmt.Printf("[DENIED - SWC] ID: %s. Exceeded long-term rate limit of %d (Est. %d).\n",
-			identifier, l.SWCLimit, estimatedCount)
-		return false
-	}
+	return nums[0] == 1, nums[1], nums[2], nil
 }
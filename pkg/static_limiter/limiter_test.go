@@ -0,0 +1,98 @@
+package limiter
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+// TestAllow_ConcurrencyRespectsSWCLimit hammers a single Limiter from many
+// goroutines and asserts the atomic Lua script never lets more requests
+// through than SWCLimit, even though they all race for the same keys.
+func TestAllow_ConcurrencyRespectsSWCLimit(t *testing.T) {
+	cases := []struct {
+		name       string
+		goroutines int
+		swcLimit   int64
+	}{
+		{name: "small fleet over limit", goroutines: 50, swcLimit: 20},
+		{name: "fleet larger than limit", goroutines: 200, swcLimit: 75},
+		{name: "fleet much larger than limit", goroutines: 500, swcLimit: 30},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			mr, err := miniredis.Run()
+			if err != nil {
+				t.Fatalf("could not start miniredis: %v", err)
+			}
+			defer mr.Close()
+
+			rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+			l := NewLimiter(rdb, context.Background())
+			// Keep the token bucket out of the way so this test is purely
+			// about the SWC race.
+			l.BucketCapacity = int64(tc.goroutines)
+			l.RefillRate = time.Nanosecond
+			l.SWCLimit = tc.swcLimit
+			l.SWCWindow = time.Minute
+
+			var allowed int64
+			var wg sync.WaitGroup
+			wg.Add(tc.goroutines)
+			for i := 0; i < tc.goroutines; i++ {
+				go func() {
+					defer wg.Done()
+					if l.Allow("same-client") {
+						atomic.AddInt64(&allowed, 1)
+					}
+				}()
+			}
+			wg.Wait()
+
+			if allowed > tc.swcLimit {
+				t.Fatalf("allowed %d requests concurrently, want <= SWCLimit %d", allowed, tc.swcLimit)
+			}
+		})
+	}
+}
+
+// TestAllow_ConcurrentNoscriptRace flushes the cached script out of Redis,
+// so the first concurrent Allow calls below hit NOSCRIPT and race to
+// re-cache scriptSHA. Run with -race: scriptSHA must be read/written
+// through getScriptSHA/setScriptSHA, not as a bare field access.
+func TestAllow_ConcurrentNoscriptRace(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("could not start miniredis: %v", err)
+	}
+	defer mr.Close()
+
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	l := NewLimiter(rdb, context.Background())
+	l.BucketCapacity = 1000
+	l.RefillRate = time.Nanosecond
+	l.SWCLimit = 1000
+	l.SWCWindow = time.Minute
+
+	if err := rdb.ScriptFlush(context.Background()).Err(); err != nil {
+		t.Fatalf("could not flush scripts: %v", err)
+	}
+
+	const goroutines = 50
+	var wg sync.WaitGroup
+	wg.Add(goroutines)
+	for i := 0; i < goroutines; i++ {
+		go func() {
+			defer wg.Done()
+			l.Allow("same-client")
+		}()
+	}
+	wg.Wait()
+}